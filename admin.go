@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// storageErrorStatus maps an error returned by a Storage method to the HTTP
+// status the admin API should respond with: ErrInvalidId (an id that isn't
+// valid for the backend in use, e.g. a non-hex MongoStorage id) means the
+// caller sent something malformed, not that the resource is missing.
+func storageErrorStatus(err error) int {
+	if errors.Is(err, ErrInvalidId) {
+		return http.StatusBadRequest
+	}
+	return http.StatusNotFound
+}
+
+// NewAdminHandler returns an http.Handler exposing a small REST API for
+// mutating the live router without requiring a full ReloadRoutes: POST
+// /routes and POST /backends add a route or backend application (persisting
+// it via the Router's Storage as they go), and DELETE /routes/{id} and
+// DELETE /backends/{id} remove them. It's intended to be served on a
+// separate listen address from the public proxy.
+func NewAdminHandler(rt *Router) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/routes", adminRoutesHandler(rt))
+	mux.HandleFunc("/routes/", adminRouteHandler(rt))
+	mux.HandleFunc("/backends", adminBackendsHandler(rt))
+	mux.HandleFunc("/backends/", adminBackendHandler(rt))
+	return mux
+}
+
+func adminRoutesHandler(rt *Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		route := &Route{}
+		if err := json.NewDecoder(r.Body).Decode(route); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := rt.AddRoute(route); err != nil {
+			log.Printf("admin: couldn't add route: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(route)
+	}
+}
+
+func adminRouteHandler(rt *Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id := strings.TrimPrefix(r.URL.Path, "/routes/")
+		if id == "" {
+			http.Error(w, "missing route id", http.StatusBadRequest)
+			return
+		}
+
+		if err := rt.RemoveRoute(id); err != nil {
+			log.Printf("admin: couldn't remove route %s: %v", id, err)
+			http.Error(w, err.Error(), storageErrorStatus(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func adminBackendsHandler(rt *Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		app := &Application{}
+		if err := json.NewDecoder(r.Body).Decode(app); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := rt.AddBackend(app); err != nil {
+			log.Printf("admin: couldn't add backend: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(app)
+	}
+}
+
+func adminBackendHandler(rt *Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id := strings.TrimPrefix(r.URL.Path, "/backends/")
+		if id == "" {
+			http.Error(w, "missing backend id", http.StatusBadRequest)
+			return
+		}
+
+		if err := rt.RemoveBackend(id); err != nil {
+			log.Printf("admin: couldn't remove backend %s: %v", id, err)
+			http.Error(w, err.Error(), storageErrorStatus(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeStorage is an in-memory Storage used to exercise the admin API
+// without a real mongo/file/etcd backend. Like MongoStorage, it rejects
+// malformed ids with ErrInvalidId rather than panicking or silently
+// accepting them, so admin_test.go can check that the admin handlers map
+// that distinctly from a well-formed-but-missing id.
+type fakeStorage struct {
+	mu     sync.Mutex
+	apps   map[string]*Application
+	routes map[string]*Route
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{
+		apps:   make(map[string]*Application),
+		routes: make(map[string]*Route),
+	}
+}
+
+// fakeIdValid mimics MongoStorage's id contract (a 24-character id) closely
+// enough to exercise the same ErrInvalidId path, without depending on the
+// real bson package.
+func fakeIdValid(id string) bool {
+	return len(id) == 24
+}
+
+func (f *fakeStorage) Open() error { return nil }
+func (f *fakeStorage) Close()      {}
+
+func (f *fakeStorage) Applications() (Iterator, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	items := make([]interface{}, 0, len(f.apps))
+	for _, app := range f.apps {
+		items = append(items, app)
+	}
+	return &sliceIterator{items: items}, nil
+}
+
+func (f *fakeStorage) Routes() (Iterator, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	items := make([]interface{}, 0, len(f.routes))
+	for _, route := range f.routes {
+		items = append(items, route)
+	}
+	return &sliceIterator{items: items}, nil
+}
+
+func (f *fakeStorage) SaveApplication(app *Application) error {
+	if app.Id == "" {
+		app.Id = generateId()
+	} else if !fakeIdValid(app.Id) {
+		return ErrInvalidId
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.apps[app.Id] = app
+	return nil
+}
+
+func (f *fakeStorage) DeleteApplication(id string) error {
+	if !fakeIdValid(id) {
+		return ErrInvalidId
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.apps[id]; !ok {
+		return errors.New("fake storage: application not found")
+	}
+	delete(f.apps, id)
+	return nil
+}
+
+func (f *fakeStorage) SaveRoute(route *Route) error {
+	if route.Id == "" {
+		route.Id = generateId()
+	} else if !fakeIdValid(route.Id) {
+		return ErrInvalidId
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.routes[route.Id] = route
+	return nil
+}
+
+func (f *fakeStorage) DeleteRoute(id string) error {
+	if !fakeIdValid(id) {
+		return ErrInvalidId
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.routes[id]; !ok {
+		return errors.New("fake storage: route not found")
+	}
+	delete(f.routes, id)
+	return nil
+}
+
+func doAdminRequest(t *testing.T, h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestAdminAddAndRemoveRoute covers the add/remove round trip the admin API
+// exists for: registering a backend, registering a route against it, then
+// removing the route.
+func TestAdminAddAndRemoveRoute(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	handler := NewAdminHandler(NewRouter(newFakeStorage()))
+
+	appBody := `{"application_id":"app1","backend_url":"` + backend.URL + `"}`
+	if rec := doAdminRequest(t, handler, "POST", "/backends", appBody); rec.Code != http.StatusCreated {
+		t.Fatalf("POST /backends: got %d, body %q", rec.Code, rec.Body.String())
+	}
+
+	routeBody := `{"incoming_host":"example.com","incoming_path":"/","application_id":"app1"}`
+	rec := doAdminRequest(t, handler, "POST", "/routes", routeBody)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST /routes: got %d, body %q", rec.Code, rec.Body.String())
+	}
+
+	var route Route
+	if err := json.Unmarshal(rec.Body.Bytes(), &route); err != nil {
+		t.Fatalf("decode route response: %v", err)
+	}
+	if route.Id == "" {
+		t.Fatal("expected route to be assigned an id")
+	}
+
+	if rec := doAdminRequest(t, handler, "DELETE", "/routes/"+route.Id, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE /routes/%s: got %d, body %q", route.Id, rec.Code, rec.Body.String())
+	}
+}
+
+// TestAdminAddRouteMalformedId checks that a client-supplied id that isn't
+// valid for the storage backend in use (e.g. a non-hex MongoStorage id) is
+// reported as a 400, not a panic (net/http would turn a panic into a
+// dropped connection rather than a clean response).
+func TestAdminAddRouteMalformedId(t *testing.T) {
+	handler := NewAdminHandler(NewRouter(newFakeStorage()))
+
+	// Register the route's backend first, so that AddRoute gets as far as
+	// storage's id validation instead of failing on an unknown application.
+	appBody := `{"application_id":"app1","backend_url":"http://backend.invalid"}`
+	if rec := doAdminRequest(t, handler, "POST", "/backends", appBody); rec.Code != http.StatusCreated {
+		t.Fatalf("POST /backends: got %d, body %q", rec.Code, rec.Body.String())
+	}
+
+	rec := doAdminRequest(t, handler, "POST", "/routes", `{"id":"not-an-id","incoming_host":"example.com","incoming_path":"/","application_id":"app1"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("POST /routes with malformed id: got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+// TestAdminRemoveRouteNotFound checks that a well-formed but unknown id is
+// still reported as a 404, distinct from the malformed-id 400 cases above
+// and below.
+func TestAdminRemoveRouteNotFound(t *testing.T) {
+	handler := NewAdminHandler(NewRouter(newFakeStorage()))
+
+	rec := doAdminRequest(t, handler, "DELETE", "/routes/"+strings.Repeat("a", 24), "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE of unknown well-formed id: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+// TestAdminRemoveRouteMalformedId checks that a malformed id passed to
+// DELETE /routes/{id} is reported as a 400 even though it was never
+// registered: RemoveRoute passes the id straight to storage (mirroring
+// RemoveBackend) rather than checking its own route index first, so
+// storage's own validation is always reached.
+func TestAdminRemoveRouteMalformedId(t *testing.T) {
+	handler := NewAdminHandler(NewRouter(newFakeStorage()))
+
+	rec := doAdminRequest(t, handler, "DELETE", "/routes/not-an-id", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("DELETE /routes/not-an-id: got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+)
+
+func main() {
+	storageBackend := flag.String("storage", "mongo", "storage backend to use: mongo, file or etcd")
+
+	mongoUrl := flag.String("mongo-url", "127.0.0.1", "mongo server(s) to connect to (storage=mongo)")
+	mongoDb := flag.String("mongo-db", "router", "mongo database to use (storage=mongo)")
+
+	filePath := flag.String("file-path", "routes.json", "path to the routes file (storage=file)")
+
+	etcdAddr := flag.String("etcd-addr", "http://127.0.0.1:4001", "etcd cluster address (storage=etcd)")
+
+	publicAddr := flag.String("public-addr", ":8080", "listen address for the public proxy")
+	adminAddr := flag.String("admin-addr", ":8081", "listen address for the admin API")
+
+	flag.Parse()
+
+	var stor Storage
+	switch *storageBackend {
+	case "mongo":
+		stor = NewMongoStorage(*mongoUrl, *mongoDb)
+	case "file":
+		stor = NewFileStorage(*filePath)
+	case "etcd":
+		stor = NewEtcdStorage([]string{*etcdAddr})
+	default:
+		log.Fatalf("router: unknown storage backend %q", *storageBackend)
+	}
+
+	rt := NewRouter(stor)
+	rt.ReloadRoutes()
+
+	switch s := stor.(type) {
+	case *FileStorage:
+		if err := s.Watch(rt.ReloadRoutes); err != nil {
+			log.Printf("router: couldn't watch %s for changes: %v", *filePath, err)
+		}
+	case *EtcdStorage:
+		go s.Watch(rt.ReloadRoutes)
+	}
+
+	go func() {
+		log.Printf("router: admin API listening on %s", *adminAddr)
+		log.Fatal(http.ListenAndServe(*adminAddr, NewAdminHandler(rt)))
+	}()
+
+	log.Printf("router: listening on %s", *publicAddr)
+	log.Fatal(http.ListenAndServe(*publicAddr, rt))
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
 )
 
 type MongoStorage struct {
@@ -12,6 +13,13 @@ type MongoStorage struct {
 	db          *mgo.Database
 }
 
+// ErrInvalidId is returned by MongoStorage's Save*/Delete* methods when
+// given an id that isn't a valid 24-character hex ObjectId, rather than
+// letting bson.ObjectIdHex panic on it -- which it does for any
+// non-conforming input, including ids coming straight from the admin API's
+// request body or URL path.
+var ErrInvalidId = errors.New("mongo storage: invalid id")
+
 func NewMongoStorage(mongoUrl, mongoDbName string) *MongoStorage {
 	return &MongoStorage{mongoUrl, mongoDbName, nil, nil}
 }
@@ -33,6 +41,64 @@ func (m *MongoStorage) Routes() (Iterator, error) {
 	return col.Find(nil).Iter(), nil
 }
 
+func (m *MongoStorage) SaveApplication(app *Application) error {
+	if app.Id == "" {
+		app.Id = bson.NewObjectId().Hex()
+	} else if !bson.IsObjectIdHex(app.Id) {
+		return ErrInvalidId
+	}
+
+	if m.db == nil {
+		return errors.New("Not connected to mongo")
+	}
+
+	col := m.db.C("applications")
+	_, err := col.UpsertId(bson.ObjectIdHex(app.Id), app)
+	return err
+}
+
+func (m *MongoStorage) DeleteApplication(id string) error {
+	if !bson.IsObjectIdHex(id) {
+		return ErrInvalidId
+	}
+
+	if m.db == nil {
+		return errors.New("Not connected to mongo")
+	}
+
+	col := m.db.C("applications")
+	return col.RemoveId(bson.ObjectIdHex(id))
+}
+
+func (m *MongoStorage) SaveRoute(route *Route) error {
+	if route.Id == "" {
+		route.Id = bson.NewObjectId().Hex()
+	} else if !bson.IsObjectIdHex(route.Id) {
+		return ErrInvalidId
+	}
+
+	if m.db == nil {
+		return errors.New("Not connected to mongo")
+	}
+
+	col := m.db.C("routes")
+	_, err := col.UpsertId(bson.ObjectIdHex(route.Id), route)
+	return err
+}
+
+func (m *MongoStorage) DeleteRoute(id string) error {
+	if !bson.IsObjectIdHex(id) {
+		return ErrInvalidId
+	}
+
+	if m.db == nil {
+		return errors.New("Not connected to mongo")
+	}
+
+	col := m.db.C("routes")
+	return col.RemoveId(bson.ObjectIdHex(id))
+}
+
 func (m *MongoStorage) Open() error {
 	var err error
 	m.sess, err = mgo.Dial(m.mongoUrl)
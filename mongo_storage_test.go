@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// These exercise MongoStorage's id validation directly, ahead of any
+// connection check, so they run against an unconnected (m.db == nil)
+// instance: that's what guarantees a malformed id is always rejected with
+// ErrInvalidId rather than ever reaching bson.ObjectIdHex, which panics on
+// non-24-hex-char input.
+
+func TestMongoStorageSaveApplicationRejectsMalformedId(t *testing.T) {
+	m := NewMongoStorage("", "")
+	err := m.SaveApplication(&Application{Id: "not-an-id"})
+	if !errors.Is(err, ErrInvalidId) {
+		t.Errorf("SaveApplication with malformed id: got %v, want ErrInvalidId", err)
+	}
+}
+
+func TestMongoStorageDeleteApplicationRejectsMalformedId(t *testing.T) {
+	m := NewMongoStorage("", "")
+	if err := m.DeleteApplication("not-an-id"); !errors.Is(err, ErrInvalidId) {
+		t.Errorf("DeleteApplication with malformed id: got %v, want ErrInvalidId", err)
+	}
+}
+
+func TestMongoStorageSaveRouteRejectsMalformedId(t *testing.T) {
+	m := NewMongoStorage("", "")
+	err := m.SaveRoute(&Route{Id: "not-an-id"})
+	if !errors.Is(err, ErrInvalidId) {
+		t.Errorf("SaveRoute with malformed id: got %v, want ErrInvalidId", err)
+	}
+}
+
+func TestMongoStorageDeleteRouteRejectsMalformedId(t *testing.T) {
+	m := NewMongoStorage("", "")
+	if err := m.DeleteRoute("not-an-id"); !errors.Is(err, ErrInvalidId) {
+		t.Errorf("DeleteRoute with malformed id: got %v, want ErrInvalidId", err)
+	}
+}
@@ -1,40 +1,90 @@
 package main
 
 import (
+	"fmt"
 	"github.com/nickstenning/router/triemux"
 	"log"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"sync"
+	"time"
 )
 
 // Router is a wrapper around an HTTP multiplexer (trie.Mux) which retrieves its
 // routes from a passed mongo database.
 type Router struct {
-	mux  *triemux.Mux
-	stor Storage
+	mux         *triemux.Mux
+	stor        Storage
+	dialTimeout time.Duration
+	idleTimeout time.Duration
+
+	mu     sync.Mutex
+	apps   map[string]*Application
+	routes map[string]routeKey
+
+	storMu     sync.Mutex
+	storOpened bool
+}
+
+// routeKey records where a route (identified by its Id) was registered in
+// the mux, so that a later admin API call to remove it by id knows what to
+// unregister.
+type routeKey struct {
+	host   string
+	path   string
+	prefix bool
 }
 
 type Application struct {
-	ApplicationId string `bson:"application_id"`
-	BackendURL    string `bson:"backend_url"`
+	Id            string    `bson:"_id,omitempty" json:"id,omitempty"`
+	ApplicationId string    `bson:"application_id" json:"application_id"`
+	BackendURL    string    `bson:"backend_url" json:"backend_url"`
+	Transform     Transform `bson:"transform,omitempty" json:"transform,omitempty"`
+}
+
+// RouteBackend names one of a route's backend applications, along with its
+// relative weight for load balancing across the route's backend pool.
+type RouteBackend struct {
+	ApplicationId string `bson:"application_id" json:"application_id"`
+	Weight        int    `bson:"weight" json:"weight"`
 }
 
 type Route struct {
-	IncomingPath  string `bson:"incoming_path"`
-	ApplicationId string `bson:"application_id"`
-	RouteType     string `bson:"route_type"`
+	Id           string `bson:"_id,omitempty" json:"id,omitempty"`
+	IncomingHost string `bson:"incoming_host" json:"incoming_host"`
+	IncomingPath string `bson:"incoming_path" json:"incoming_path"`
+	RouteType    string `bson:"route_type" json:"route_type"`
+
+	// ApplicationId is the legacy single-backend form of a route. Routes with
+	// more than one backend should use Backends instead; if Backends is
+	// empty, ApplicationId is treated as a single backend with weight 1.
+	ApplicationId string         `bson:"application_id,omitempty" json:"application_id,omitempty"`
+	Backends      []RouteBackend `bson:"backends,omitempty" json:"backends,omitempty"`
 }
 
 // NewRouter returns a new empty router instance. You will still need to call
 // ReloadRoutes() to do the initial route load.
 func NewRouter(stor Storage) *Router {
 	return &Router{
-		mux:  triemux.NewMux(),
-		stor: stor,
+		mux:         triemux.NewMux(),
+		stor:        stor,
+		dialTimeout: defaultUpgradeDialTimeout,
+		idleTimeout: defaultUpgradeIdleTimeout,
+		apps:        make(map[string]*Application),
+		routes:      make(map[string]routeKey),
 	}
 }
 
+// SetUpgradeTimeouts configures the timeouts used when a request asks to be
+// upgraded (e.g. WebSockets): dial bounds how long connecting to the backend
+// may take, and idle bounds how long either side of a piped connection may
+// go without sending data before it's torn down.
+func (rt *Router) SetUpgradeTimeouts(dial, idle time.Duration) {
+	rt.dialTimeout = dial
+	rt.idleTimeout = idle
+}
+
 // ServeHTTP delegates responsibility for serving requests to the proxy mux
 // instance for this router.
 func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -55,29 +105,172 @@ func (rt *Router) ReloadRoutes() {
 		}
 	}()
 
-	log.Println("connecting to storage")
-	err := rt.stor.Open()
-	if err != nil {
+	if err := rt.ensureStorage(); err != nil {
 		panic(err)
 	}
-	defer rt.stor.Close()
 
 	log.Printf("router: reloading routes")
 	newmux := triemux.NewMux()
 
 	apps := loadApplications(rt.stor)
-	loadRoutes(rt.stor, newmux, apps)
+	routes := loadRoutes(rt.stor, newmux, apps, rt.dialTimeout, rt.idleTimeout)
 
+	rt.mu.Lock()
 	rt.mux = newmux
+	rt.apps = apps
+	rt.routes = routes
+	rt.mu.Unlock()
 	log.Printf("router: reloaded routes")
 }
 
+// ensureStorage opens the storage connection the first time it's called,
+// and is a no-op after that. ReloadRoutes and the admin API methods below
+// all share a single long-lived storage connection opened this way, rather
+// than each one dialling and hanging up on every call. It's guarded by its
+// own mutex, separate from rt.mu, so a slow or unreachable storage backend
+// only blocks other callers of ensureStorage and not unrelated access to
+// rt.apps/rt.routes.
+func (rt *Router) ensureStorage() error {
+	rt.storMu.Lock()
+	defer rt.storMu.Unlock()
+
+	if rt.storOpened {
+		return nil
+	}
+
+	log.Println("connecting to storage")
+	if err := rt.stor.Open(); err != nil {
+		return err
+	}
+
+	rt.storOpened = true
+	return nil
+}
+
+// AddBackend registers a new backend application, persists it through
+// storage, and makes it available for subsequent calls to AddRoute. It
+// doesn't affect any routes already registered against an application with
+// the same id -- reload or re-register those routes to pick up the change.
+func (rt *Router) AddBackend(app *Application) error {
+	if _, err := url.Parse(app.BackendURL); err != nil {
+		return err
+	}
+
+	if err := rt.ensureStorage(); err != nil {
+		return err
+	}
+
+	if err := rt.stor.SaveApplication(app); err != nil {
+		return err
+	}
+
+	rt.mu.Lock()
+	rt.apps[app.ApplicationId] = app
+	rt.mu.Unlock()
+
+	return nil
+}
+
+// RemoveBackend deletes the backend application with the given id from
+// storage and from rt.apps. It does not touch any routes already
+// registered against it: their handlers were built and installed in the
+// mux at AddRoute/ReloadRoutes time, so they keep serving through the
+// backend's existing handler (including its own health checks) until
+// they're re-registered or the router is reloaded. Callers that want
+// those routes to stop serving should remove or re-point them first.
+func (rt *Router) RemoveBackend(id string) error {
+	if err := rt.ensureStorage(); err != nil {
+		return err
+	}
+
+	if err := rt.stor.DeleteApplication(id); err != nil {
+		return err
+	}
+
+	// rt.apps is keyed by ApplicationId, not by the storage id.
+	rt.mu.Lock()
+	for applicationId, app := range rt.apps {
+		if app.Id == id {
+			delete(rt.apps, applicationId)
+		}
+	}
+	rt.mu.Unlock()
+
+	return nil
+}
+
+// AddRoute registers a new route for one or more already-registered backend
+// applications, persists it through storage, and adds it to the live mux.
+func (rt *Router) AddRoute(route *Route) error {
+	if err := rt.ensureStorage(); err != nil {
+		return err
+	}
+
+	// Copy rt.apps while holding the lock rather than just copying the map
+	// reference: rt.apps can be replaced wholesale (ReloadRoutes) or mutated
+	// in place (AddBackend/RemoveBackend) by another goroutine, and reading
+	// from it after releasing the lock would be a concurrent map read/write.
+	rt.mu.Lock()
+	apps := make(map[string]*Application, len(rt.apps))
+	for id, app := range rt.apps {
+		apps[id] = app
+	}
+	rt.mu.Unlock()
+
+	backends, err := buildRouteBackends(route, apps, rt.dialTimeout, rt.idleTimeout)
+	if err != nil {
+		return err
+	}
+
+	if err := rt.stor.SaveRoute(route); err != nil {
+		return err
+	}
+
+	prefix := (route.RouteType == "prefix")
+	rt.mux.HandleBackends(route.IncomingHost, route.IncomingPath, prefix, backends)
+
+	rt.mu.Lock()
+	rt.routes[route.Id] = routeKey{host: route.IncomingHost, path: route.IncomingPath, prefix: prefix}
+	rt.mu.Unlock()
+
+	return nil
+}
+
+// RemoveRoute deletes the route with the given id from storage and
+// unregisters it from the live mux. Like RemoveBackend, the id is passed
+// straight to storage rather than checked against rt.routes first, so that
+// an id storage rejects (e.g. a malformed MongoStorage id) is reported as
+// such rather than masked behind a generic "unknown route".
+func (rt *Router) RemoveRoute(id string) error {
+	if err := rt.ensureStorage(); err != nil {
+		return err
+	}
+
+	if err := rt.stor.DeleteRoute(id); err != nil {
+		return err
+	}
+
+	rt.mu.Lock()
+	key, ok := rt.routes[id]
+	rt.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	rt.mux.Unregister(key.host, key.path)
+
+	rt.mu.Lock()
+	delete(rt.routes, id)
+	rt.mu.Unlock()
+
+	return nil
+}
+
 // loadApplications is a helper function which loads applications from the
-// passed mongo collection and registers them as backends with the passed proxy
-// mux.
-func loadApplications(stor Storage) (apps map[string]http.Handler) {
+// passed storage backend, keyed by application id.
+func loadApplications(stor Storage) (apps map[string]*Application) {
 	app := &Application{}
-	apps = make(map[string]http.Handler)
+	apps = make(map[string]*Application)
 
 	iter, err := stor.Applications()
 
@@ -86,32 +279,123 @@ func loadApplications(stor Storage) (apps map[string]http.Handler) {
 	}
 
 	for iter.Next(&app) {
-		backendUrl, err := url.Parse(app.BackendURL)
+		a := *app
+		apps[a.ApplicationId] = &a
+	}
+
+	if err := iter.Err(); err != nil {
+		panic(err)
+	}
+
+	return
+}
+
+// newBackendHandler builds the http.Handler for a single backend
+// application: a reverse proxy to its URL, wrapped to support upgrade and
+// streaming requests. Both the transform and the forwarded-header rewriting
+// applied to ordinary requests by the Director below are also applied to
+// upgrade requests by newUpgradeProxy, and backend is notified of upgrade
+// dial/write failures and successes the same way wireHealthCheck notifies it
+// of ordinary request outcomes, so a backend's health and an application's
+// transform apply uniformly regardless of which path a request takes. The
+// underlying *httputil.ReverseProxy is also returned so that callers can
+// attach health-check hooks to it.
+func newBackendHandler(app *Application, dialTimeout, idleTimeout time.Duration, backend *triemux.Backend) (http.Handler, *httputil.ReverseProxy, error) {
+	backendUrl, err := url.Parse(app.BackendURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(backendUrl)
+	// Allow the proxy to keep more than the default (2) keepalive connections
+	// per upstream.
+	proxy.Transport = &http.Transport{MaxIdleConnsPerHost: 20}
+
+	// Wrap the default director to apply the application's configured
+	// request transform, and to append the X-Forwarded-* headers describing
+	// the request this router received (which have to be captured before the
+	// transform potentially rewrites req.Host).
+	defaultDirector := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		host, proto := req.Host, requestScheme(req)
+
+		defaultDirector(req)
+		applyTransform(req, backendUrl, app.Transform)
+		appendForwardedHeaders(req.Header, host, proto)
+	}
+
+	// Upgrade requests (WebSockets, etc.) can't go through
+	// httputil.ReverseProxy, so wrap it in a handler that detects them and
+	// handles them itself.
+	handler := newUpgradeProxy(backendUrl, proxy, app.Transform, dialTimeout, idleTimeout, backend.MarkFailure, backend.MarkSuccess)
+
+	return handler, proxy, nil
+}
+
+// buildRouteBackends resolves a route's backend application(s) -- either its
+// single legacy ApplicationId, or its weighted Backends list -- against apps,
+// returning a pool of triemux.Backend instances each with a passive health
+// check wired up via wireHealthCheck.
+func buildRouteBackends(route *Route, apps map[string]*Application, dialTimeout, idleTimeout time.Duration) ([]*triemux.Backend, error) {
+	refs := route.Backends
+	if len(refs) == 0 {
+		refs = []RouteBackend{{ApplicationId: route.ApplicationId, Weight: 1}}
+	}
+
+	backends := make([]*triemux.Backend, 0, len(refs))
+	for _, ref := range refs {
+		app, ok := apps[ref.ApplicationId]
+		if !ok {
+			return nil, fmt.Errorf("route %+v references unknown application %s", route, ref.ApplicationId)
+		}
+
+		// The backend is constructed before its handler so that the handler
+		// (which drives both the ordinary and upgrade request paths) can be
+		// wired to report successes and failures against it.
+		backend := triemux.NewBackend(nil, ref.Weight)
+
+		handler, proxy, err := newBackendHandler(app, dialTimeout, idleTimeout, backend)
 		if err != nil {
-			log.Printf("router: couldn't parse URL %s for backend %s "+
-				"(error: %v), skipping!", app.BackendURL, app.ApplicationId, err)
-			continue
+			return nil, fmt.Errorf("couldn't parse URL %s for backend %s (error: %v)",
+				app.BackendURL, app.ApplicationId, err)
 		}
+		backend.Handler = handler
 
-		proxy := httputil.NewSingleHostReverseProxy(backendUrl)
-		// Allow the proxy to keep more than the default (2) keepalive connections
-		// per upstream.
-		proxy.Transport = &http.Transport{MaxIdleConnsPerHost: 20}
+		wireHealthCheck(proxy, backend)
 
-		apps[app.ApplicationId] = proxy
+		backends = append(backends, backend)
 	}
 
-	if err := iter.Err(); err != nil {
-		panic(err)
+	return backends, nil
+}
+
+// wireHealthCheck attaches hooks to proxy so that dial/backend errors and 5xx
+// responses mark backend as failed, taking it out of its route's picker once
+// enough failures have accumulated, and so that other responses mark it
+// healthy again.
+func wireHealthCheck(proxy *httputil.ReverseProxy, backend *triemux.Backend) {
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		backend.MarkFailure()
+		http.Error(w, "backend unavailable", http.StatusBadGateway)
 	}
 
-	return
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if resp.StatusCode >= 500 {
+			backend.MarkFailure()
+		} else {
+			backend.MarkSuccess()
+		}
+		return nil
+	}
 }
 
-// loadRoutes is a helper function which loads routes from the passed mongo
-// collection and registers them with the passed proxy mux.
-func loadRoutes(stor Storage, mux *triemux.Mux, apps map[string]http.Handler) {
+// loadRoutes is a helper function which loads routes from the passed storage
+// backend and registers them with the passed mux. It returns an index from
+// route id to where each route was registered, so that routes can later be
+// unregistered by id via the admin API.
+func loadRoutes(stor Storage, mux *triemux.Mux, apps map[string]*Application, dialTimeout, idleTimeout time.Duration) (routes map[string]routeKey) {
 	route := &Route{}
+	routes = make(map[string]routeKey)
 
 	iter, err := stor.Routes()
 
@@ -120,20 +404,25 @@ func loadRoutes(stor Storage, mux *triemux.Mux, apps map[string]http.Handler) {
 	}
 
 	for iter.Next(&route) {
-		handler, ok := apps[route.ApplicationId]
-		if !ok {
-			log.Printf("router: found route %+v which references unknown application "+
-				"%s, skipping!", route, route.ApplicationId)
+		backends, err := buildRouteBackends(route, apps, dialTimeout, idleTimeout)
+		if err != nil {
+			log.Printf("router: %v, skipping!", err)
 			continue
 		}
 
 		prefix := (route.RouteType == "prefix")
-		mux.Handle(route.IncomingPath, prefix, handler)
-		log.Printf("router: registered %s (prefix: %v) for %s",
-			route.IncomingPath, prefix, route.ApplicationId)
+		mux.HandleBackends(route.IncomingHost, route.IncomingPath, prefix, backends)
+		log.Printf("router: registered %s%s (prefix: %v) with %d backend(s)",
+			route.IncomingHost, route.IncomingPath, prefix, len(backends))
+
+		if route.Id != "" {
+			routes[route.Id] = routeKey{host: route.IncomingHost, path: route.IncomingPath, prefix: prefix}
+		}
 	}
 
 	if err := iter.Err(); err != nil {
 		panic(err)
 	}
+
+	return
 }
@@ -1,5 +1,18 @@
 package main
 
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"reflect"
+)
+
+// ErrNotFound is returned by a Storage implementation's Delete* methods
+// when asked to delete an id that doesn't exist, so that callers (the
+// admin API in particular) can report a 404 rather than silently treating
+// the delete as having done something.
+var ErrNotFound = errors.New("storage: not found")
+
 type Iterator interface {
 	Next(result interface{}) bool
 	Err() error
@@ -8,6 +21,39 @@ type Iterator interface {
 type Storage interface {
 	Applications() (Iterator, error)
 	Routes() (Iterator, error)
+	SaveApplication(app *Application) error
+	DeleteApplication(id string) error
+	SaveRoute(route *Route) error
+	DeleteRoute(id string) error
 	Open() error
 	Close()
 }
+
+// sliceIterator is an Iterator over an in-memory slice of *Application or
+// *Route pointers, shared by the non-mongo Storage implementations.
+type sliceIterator struct {
+	items []interface{}
+	i     int
+}
+
+func (it *sliceIterator) Next(result interface{}) bool {
+	if it.i >= len(it.items) {
+		return false
+	}
+
+	reflect.ValueOf(result).Elem().Set(reflect.ValueOf(it.items[it.i]))
+	it.i++
+	return true
+}
+
+func (it *sliceIterator) Err() error {
+	return nil
+}
+
+// generateId returns a random hex id, used by Storage implementations that
+// don't have a natural id generator of their own (unlike mongo's ObjectId).
+func generateId() string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
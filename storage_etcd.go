@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/coreos/go-etcd/etcd"
+	"log"
+	"time"
+)
+
+const (
+	etcdApplicationsPrefix = "/applications/"
+	etcdRoutesPrefix       = "/routes/"
+
+	// etcdWatchRetryDelay is how long watchPrefix waits before retrying a
+	// failed Watch call, so that an unreachable etcd doesn't get hammered
+	// with reconnect attempts in a tight loop.
+	etcdWatchRetryDelay = 5 * time.Second
+)
+
+// EtcdStorage is a Storage implementation that keeps applications and
+// routes as JSON-encoded values in etcd, under the /applications/ and
+// /routes/ prefixes respectively, and can watch those prefixes for changes
+// so a running router can be kept up to date without a restart.
+type EtcdStorage struct {
+	client *etcd.Client
+}
+
+// NewEtcdStorage returns an EtcdStorage talking to the given etcd cluster
+// machines (e.g. "http://127.0.0.1:4001").
+func NewEtcdStorage(machines []string) *EtcdStorage {
+	return &EtcdStorage{client: etcd.NewClient(machines)}
+}
+
+func (e *EtcdStorage) Open() error {
+	return nil
+}
+
+func (e *EtcdStorage) Close() {}
+
+func (e *EtcdStorage) Applications() (Iterator, error) {
+	return e.list(etcdApplicationsPrefix, func() interface{} { return &Application{} })
+}
+
+func (e *EtcdStorage) Routes() (Iterator, error) {
+	return e.list(etcdRoutesPrefix, func() interface{} { return &Route{} })
+}
+
+// list fetches every value under prefix and decodes each one with newItem,
+// skipping (and logging) any that fail to decode.
+func (e *EtcdStorage) list(prefix string, newItem func() interface{}) (Iterator, error) {
+	resp, err := e.client.Get(prefix, false, true)
+	if err != nil {
+		if etcdErr, ok := err.(*etcd.EtcdError); ok && etcdErr.ErrorCode == etcd.EcodeKeyNotFound {
+			// The prefix hasn't been created yet, which just means there's
+			// nothing stored there.
+			return &sliceIterator{}, nil
+		}
+		// Anything else (etcd down, network partition, bad address, ...) is a
+		// real failure and must propagate, not be treated as "no routes" --
+		// ReloadRoutes relies on this to keep serving the last good routes
+		// rather than silently wiping them out.
+		return nil, err
+	}
+
+	items := make([]interface{}, 0, len(resp.Node.Nodes))
+	for _, node := range resp.Node.Nodes {
+		item := newItem()
+		if err := json.Unmarshal([]byte(node.Value), item); err != nil {
+			log.Printf("router: couldn't unmarshal etcd node %s: %v", node.Key, err)
+			continue
+		}
+		items = append(items, item)
+	}
+
+	return &sliceIterator{items: items}, nil
+}
+
+func (e *EtcdStorage) SaveApplication(app *Application) error {
+	if app.Id == "" {
+		app.Id = generateId()
+	}
+	return e.set(etcdApplicationsPrefix+app.Id, app)
+}
+
+func (e *EtcdStorage) DeleteApplication(id string) error {
+	_, err := e.client.Delete(etcdApplicationsPrefix+id, false)
+	return err
+}
+
+func (e *EtcdStorage) SaveRoute(route *Route) error {
+	if route.Id == "" {
+		route.Id = generateId()
+	}
+	return e.set(etcdRoutesPrefix+route.Id, route)
+}
+
+func (e *EtcdStorage) DeleteRoute(id string) error {
+	_, err := e.client.Delete(etcdRoutesPrefix+id, false)
+	return err
+}
+
+func (e *EtcdStorage) set(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = e.client.Set(key, string(data), 0)
+	return err
+}
+
+// Watch starts one goroutine per prefix that calls reload every time a key
+// under /applications/ or /routes/ changes, and returns without waiting for
+// them.
+func (e *EtcdStorage) Watch(reload func()) {
+	for _, prefix := range []string{etcdApplicationsPrefix, etcdRoutesPrefix} {
+		go e.watchPrefix(prefix, reload)
+	}
+}
+
+func (e *EtcdStorage) watchPrefix(prefix string, reload func()) {
+	for {
+		if _, err := e.client.Watch(prefix, 0, true, nil, nil); err != nil {
+			log.Printf("router: etcd watch of %s failed: %v", prefix, err)
+			time.Sleep(etcdWatchRetryDelay)
+			continue
+		}
+		reload()
+	}
+}
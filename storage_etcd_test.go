@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// TestEtcdStorageAddAndRemoveRoute exercises EtcdStorage against a real etcd
+// cluster, since its client is a concrete *etcd.Client rather than an
+// interface this package can fake. It's skipped unless ROUTER_TEST_ETCD
+// points at one (e.g. "http://127.0.0.1:4001"), so that the suite runs
+// without an etcd server rather than trying to fake the wire protocol.
+func TestEtcdStorageAddAndRemoveRoute(t *testing.T) {
+	machine := os.Getenv("ROUTER_TEST_ETCD")
+	if machine == "" {
+		t.Skip("ROUTER_TEST_ETCD not set, skipping etcd integration test")
+	}
+
+	stor := NewEtcdStorage([]string{machine})
+
+	route := &Route{IncomingHost: "example.com", IncomingPath: "/"}
+	if err := stor.SaveRoute(route); err != nil {
+		t.Fatalf("SaveRoute: %v", err)
+	}
+	if route.Id == "" {
+		t.Fatal("SaveRoute didn't assign an id")
+	}
+
+	found := false
+	iter, err := stor.Routes()
+	if err != nil {
+		t.Fatalf("Routes: %v", err)
+	}
+	r := &Route{}
+	for iter.Next(&r) {
+		if r.Id == route.Id {
+			found = true
+		}
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("iterating routes: %v", err)
+	}
+	if !found {
+		t.Fatal("saved route not found in Routes()")
+	}
+
+	if err := stor.DeleteRoute(route.Id); err != nil {
+		t.Fatalf("DeleteRoute: %v", err)
+	}
+}
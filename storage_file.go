@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/howeyc/fsnotify"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sync"
+)
+
+// fileDocument is the on-disk representation of a FileStorage's data: a
+// flat list of applications and a flat list of routes, structured the same
+// way as the equivalent mongo collections.
+type fileDocument struct {
+	Applications []*Application `json:"applications"`
+	Routes       []*Route       `json:"routes"`
+}
+
+// FileStorage is a Storage implementation that reads applications and
+// routes from a single JSON file on disk. It can optionally watch that file
+// for changes, so a running router can pick up edits without a restart.
+// This gives users a git-ops-friendly way to configure the router without
+// needing a mongo server.
+type FileStorage struct {
+	path string
+
+	mu  sync.Mutex
+	doc fileDocument
+}
+
+// NewFileStorage returns a FileStorage that reads its applications and
+// routes from the JSON file at path.
+func NewFileStorage(path string) *FileStorage {
+	return &FileStorage{path: path}
+}
+
+func (f *FileStorage) Open() error {
+	return f.load()
+}
+
+func (f *FileStorage) Close() {}
+
+func (f *FileStorage) load() error {
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	var doc fileDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.doc = doc
+	f.mu.Unlock()
+
+	return nil
+}
+
+func (f *FileStorage) Applications() (Iterator, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	items := make([]interface{}, len(f.doc.Applications))
+	for i, app := range f.doc.Applications {
+		items[i] = app
+	}
+	return &sliceIterator{items: items}, nil
+}
+
+func (f *FileStorage) Routes() (Iterator, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	items := make([]interface{}, len(f.doc.Routes))
+	for i, route := range f.doc.Routes {
+		items[i] = route
+	}
+	return &sliceIterator{items: items}, nil
+}
+
+func (f *FileStorage) SaveApplication(app *Application) error {
+	f.mu.Lock()
+	if app.Id == "" {
+		app.Id = generateId()
+	}
+	replaced := false
+	for i, existing := range f.doc.Applications {
+		if existing.Id == app.Id {
+			f.doc.Applications[i] = app
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		f.doc.Applications = append(f.doc.Applications, app)
+	}
+	doc := f.doc
+	f.mu.Unlock()
+
+	return f.save(doc)
+}
+
+func (f *FileStorage) DeleteApplication(id string) error {
+	f.mu.Lock()
+	found := false
+	for i, existing := range f.doc.Applications {
+		if existing.Id == id {
+			f.doc.Applications = append(f.doc.Applications[:i], f.doc.Applications[i+1:]...)
+			found = true
+			break
+		}
+	}
+	doc := f.doc
+	f.mu.Unlock()
+
+	if !found {
+		return ErrNotFound
+	}
+	return f.save(doc)
+}
+
+func (f *FileStorage) SaveRoute(route *Route) error {
+	f.mu.Lock()
+	if route.Id == "" {
+		route.Id = generateId()
+	}
+	replaced := false
+	for i, existing := range f.doc.Routes {
+		if existing.Id == route.Id {
+			f.doc.Routes[i] = route
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		f.doc.Routes = append(f.doc.Routes, route)
+	}
+	doc := f.doc
+	f.mu.Unlock()
+
+	return f.save(doc)
+}
+
+func (f *FileStorage) DeleteRoute(id string) error {
+	f.mu.Lock()
+	found := false
+	for i, existing := range f.doc.Routes {
+		if existing.Id == id {
+			f.doc.Routes = append(f.doc.Routes[:i], f.doc.Routes[i+1:]...)
+			found = true
+			break
+		}
+	}
+	doc := f.doc
+	f.mu.Unlock()
+
+	if !found {
+		return ErrNotFound
+	}
+	return f.save(doc)
+}
+
+// save serialises doc back out to the file at f.path.
+func (f *FileStorage) save(doc fileDocument) error {
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(f.path, data, 0644)
+}
+
+// Watch starts an fsnotify watcher on the storage file and calls reload
+// every time it changes, until the watcher is told to stop by the caller
+// closing the process. It returns once the watcher has been set up; the
+// watch itself runs in a goroutine.
+func (f *FileStorage) Watch(reload func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	if err := watcher.Watch(filepath.Dir(f.path)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		for {
+			select {
+			case ev := <-watcher.Event:
+				if filepath.Clean(ev.Name) != filepath.Clean(f.path) {
+					continue
+				}
+				if err := f.load(); err != nil {
+					log.Printf("router: couldn't reload %s: %v", f.path, err)
+					continue
+				}
+				reload()
+			case err := <-watcher.Error:
+				log.Printf("router: error watching %s: %v", f.path, err)
+			}
+		}
+	}()
+
+	return nil
+}
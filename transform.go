@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Transform describes how an incoming request should be rewritten before
+// it's sent on to an application's backend: whether to rewrite the Host
+// header to match the backend (needed by many virtual-hosted origins),
+// headers to add or remove, and a path prefix to strip and/or add.
+type Transform struct {
+	RewriteHost     bool              `bson:"rewrite_host" json:"rewrite_host"`
+	SetHeaders      map[string]string `bson:"set_headers,omitempty" json:"set_headers,omitempty"`
+	RemoveHeaders   []string          `bson:"remove_headers,omitempty" json:"remove_headers,omitempty"`
+	StripPathPrefix string            `bson:"strip_path_prefix,omitempty" json:"strip_path_prefix,omitempty"`
+	AddPathPrefix   string            `bson:"add_path_prefix,omitempty" json:"add_path_prefix,omitempty"`
+}
+
+// applyTransform rewrites req in place according to t. It should be called
+// after the reverse proxy's default director has already pointed req at
+// target, since StripPathPrefix/AddPathPrefix act on the request's final
+// path.
+func applyTransform(req *http.Request, target *url.URL, t Transform) {
+	if t.RewriteHost {
+		req.Host = target.Host
+	}
+
+	req.URL.Path = transformPath(req.URL.Path, t)
+	applyHeaderTransform(req.Header, t)
+}
+
+// transformPath applies t's StripPathPrefix/AddPathPrefix to path. It's
+// split out from applyTransform so the upgrade path (upgrade.go), which
+// doesn't have a *http.Request pointed at the backend to hand to
+// applyTransform, can apply the same rewrite to the raw request line it
+// replays onto the backend connection.
+func transformPath(path string, t Transform) string {
+	if t.StripPathPrefix != "" {
+		path = strings.TrimPrefix(path, t.StripPathPrefix)
+	}
+	if t.AddPathPrefix != "" {
+		path = t.AddPathPrefix + path
+	}
+	return path
+}
+
+// applyHeaderTransform applies t's SetHeaders/RemoveHeaders to h. Split out
+// from applyTransform for the same reason as transformPath.
+func applyHeaderTransform(h http.Header, t Transform) {
+	for _, name := range t.RemoveHeaders {
+		h.Del(name)
+	}
+	for k, v := range t.SetHeaders {
+		h.Set(k, v)
+	}
+}
+
+// appendForwardedHeaders adds X-Forwarded-Host and X-Forwarded-Proto to h,
+// describing the original request as seen by this router. Either header that
+// is already present (e.g. set by a proxy in front of this router) is kept
+// as it is rather than overwritten. host and proto
+// should be captured before any RewriteHost transform runs, since that
+// overwrites the request's Host.
+//
+// X-Forwarded-For is deliberately left alone here: httputil.ReverseProxy's
+// own ServeHTTP already appends the immediate peer to any X-Forwarded-For
+// it finds on the outgoing request (which is exactly the "preserve what a
+// proxy in front of us set, append our peer" behaviour we want), so setting
+// it here too would double up the peer address on every request. Upgrade
+// requests bypass ReverseProxy, though, so the upgrade path appends
+// X-Forwarded-For itself via appendForwardedFor below.
+func appendForwardedHeaders(h http.Header, host, proto string) {
+	if h.Get("X-Forwarded-Host") == "" {
+		h.Set("X-Forwarded-Host", host)
+	}
+	if h.Get("X-Forwarded-Proto") == "" {
+		h.Set("X-Forwarded-Proto", proto)
+	}
+}
+
+// appendForwardedFor appends the immediate peer's address (from remoteAddr,
+// typically a request's RemoteAddr) to X-Forwarded-For on h, preserving any
+// value already there from a proxy in front of this router. This is the
+// same behaviour httputil.ReverseProxy applies automatically; callers that
+// bypass it (the upgrade path) need to apply it themselves.
+func appendForwardedFor(h http.Header, remoteAddr string) {
+	clientIP, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		clientIP = remoteAddr
+	}
+
+	if prior := h.Get("X-Forwarded-For"); prior != "" {
+		clientIP = prior + ", " + clientIP
+	}
+	h.Set("X-Forwarded-For", clientIP)
+}
+
+// requestScheme returns "https" if req arrived over TLS, and "http"
+// otherwise.
+func requestScheme(req *http.Request) string {
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/nickstenning/router/triemux"
+)
+
+// TestBackendHandlerAppliesTransform exercises newBackendHandler's Director
+// wrapping end to end: a request through the returned handler should arrive
+// at the backend with its transform applied, on top of the usual
+// single-host-reverse-proxy rewriting.
+func TestBackendHandlerAppliesTransform(t *testing.T) {
+	var gotHost, gotPath string
+	var gotForwardedHost, gotForwardedProto string
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost = r.Host
+		gotPath = r.URL.Path
+		gotForwardedHost = r.Header.Get("X-Forwarded-Host")
+		gotForwardedProto = r.Header.Get("X-Forwarded-Proto")
+	}))
+	defer backend.Close()
+
+	app := &Application{
+		ApplicationId: "widgets",
+		BackendURL:    backend.URL,
+		Transform: Transform{
+			RewriteHost:     true,
+			StripPathPrefix: "/api",
+		},
+	}
+
+	b := triemux.NewBackend(nil, 1)
+	handler, _, err := newBackendHandler(app, time.Second, time.Second, b)
+	if err != nil {
+		t.Fatalf("newBackendHandler: %v", err)
+	}
+	b.Handler = handler
+
+	frontend := httptest.NewServer(handler)
+	defer frontend.Close()
+
+	resp, err := http.Get(frontend.URL + "/api/widgets")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	resp.Body.Close()
+
+	backendURL, _ := url.Parse(backend.URL)
+	if gotHost != backendURL.Host {
+		t.Errorf("Host = %q, want %q (RewriteHost: true)", gotHost, backendURL.Host)
+	}
+	if gotPath != "/widgets" {
+		t.Errorf("Path = %q, want %q (StripPathPrefix: /api)", gotPath, "/widgets")
+	}
+	if gotForwardedHost == "" {
+		t.Error("X-Forwarded-Host not set")
+	}
+	if gotForwardedProto != "http" {
+		t.Errorf("X-Forwarded-Proto = %q, want %q", gotForwardedProto, "http")
+	}
+}
+
+func TestTransformPath(t *testing.T) {
+	cases := []struct {
+		path string
+		t    Transform
+		want string
+	}{
+		{"/api/widgets", Transform{StripPathPrefix: "/api"}, "/widgets"},
+		{"/widgets", Transform{AddPathPrefix: "/api"}, "/api/widgets"},
+		{"/widgets", Transform{}, "/widgets"},
+	}
+
+	for _, c := range cases {
+		if got := transformPath(c.path, c.t); got != c.want {
+			t.Errorf("transformPath(%q, %+v) = %q, want %q", c.path, c.t, got, c.want)
+		}
+	}
+}
+
+func TestAppendForwardedFor(t *testing.T) {
+	h := make(http.Header)
+	appendForwardedFor(h, "10.0.0.1:54321")
+	if got := h.Get("X-Forwarded-For"); got != "10.0.0.1" {
+		t.Errorf("X-Forwarded-For = %q, want %q", got, "10.0.0.1")
+	}
+
+	appendForwardedFor(h, "10.0.0.2:12345")
+	if got := h.Get("X-Forwarded-For"); got != "10.0.0.1, 10.0.0.2" {
+		t.Errorf("X-Forwarded-For = %q, want %q", got, "10.0.0.1, 10.0.0.2")
+	}
+}
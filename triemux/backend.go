@@ -0,0 +1,156 @@
+package triemux
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Default health-check parameters for a Backend: how many consecutive
+// failures take it out of the pool, and how long it stays out before being
+// tried again.
+const (
+	DefaultFailureThreshold = 5
+	DefaultCooldown         = 30 * time.Second
+)
+
+// Backend pairs a handler with a weight (used for weighted round-robin
+// selection among a route's backends) and passive health tracking: once
+// MarkFailure has been called FailureThreshold times in a row, the backend
+// is skipped by the picker for Cooldown, after which it's tried again. A
+// call to MarkSuccess resets the failure count.
+type Backend struct {
+	Handler          http.Handler
+	Weight           int
+	FailureThreshold int
+	Cooldown         time.Duration
+
+	mu                  sync.Mutex
+	currentWeight       int
+	consecutiveFailures int
+	unhealthyUntil      time.Time
+}
+
+// NewBackend returns a Backend wrapping handler with the given weight (a
+// weight of zero or less is replaced by 1) and the default health-check
+// parameters.
+func NewBackend(handler http.Handler, weight int) *Backend {
+	if weight <= 0 {
+		weight = 1
+	}
+	return &Backend{
+		Handler:          handler,
+		Weight:           weight,
+		FailureThreshold: DefaultFailureThreshold,
+		Cooldown:         DefaultCooldown,
+	}
+}
+
+// MarkFailure records a failed request against the backend (e.g. a dial
+// error or a 5xx response), taking it out of the pool for Cooldown once
+// FailureThreshold consecutive failures have been seen.
+func (b *Backend) MarkFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.consecutiveFailures++
+	if b.consecutiveFailures >= b.FailureThreshold {
+		b.unhealthyUntil = time.Now().Add(b.Cooldown)
+	}
+}
+
+// MarkSuccess records a successful request against the backend, resetting
+// its failure count and making it immediately eligible for selection again.
+func (b *Backend) MarkSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.consecutiveFailures = 0
+	b.unhealthyUntil = time.Time{}
+}
+
+// healthy reports whether the backend's cooldown (if any) has expired.
+func (b *Backend) healthy(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.unhealthyUntil.IsZero() || !b.unhealthyUntil.After(now)
+}
+
+// bump adds the backend's weight to its running currentWeight (the Nginx
+// smooth weighted round-robin algorithm) and returns the new value.
+func (b *Backend) bump() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.currentWeight += b.Weight
+	return b.currentWeight
+}
+
+// drain subtracts total (the sum of weights considered in this pick) from
+// the backend's currentWeight. Called on the backend chosen by a pick.
+func (b *Backend) drain(total int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.currentWeight -= total
+}
+
+// picker selects among a route's backends using smooth weighted
+// round-robin, skipping any that are currently marked unhealthy.
+type picker struct {
+	mu       sync.Mutex
+	backends []*Backend
+}
+
+// newPicker returns a picker over the given backends.
+func newPicker(backends []*Backend) *picker {
+	return &picker{backends: backends}
+}
+
+// pick returns the next backend to use, or nil if the picker has no
+// backends at all. If every backend is unhealthy, pick considers them all
+// anyway, so that one gets a chance to recover rather than every request
+// failing outright.
+func (p *picker) pick() *Backend {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if best := p.pickFrom(healthyBackends(p.backends)); best != nil {
+		return best
+	}
+	return p.pickFrom(p.backends)
+}
+
+// pickFrom runs one round of the smooth weighted round-robin algorithm over
+// candidates: each backend's currentWeight is bumped by its weight, the
+// backend with the largest currentWeight wins, and the total weight
+// considered is then subtracted from the winner's currentWeight.
+func (p *picker) pickFrom(candidates []*Backend) *Backend {
+	var total int
+	var best *Backend
+	var bestWeight int
+
+	for _, b := range candidates {
+		total += b.Weight
+		cw := b.bump()
+		if best == nil || cw > bestWeight {
+			best, bestWeight = b, cw
+		}
+	}
+
+	if best != nil {
+		best.drain(total)
+	}
+	return best
+}
+
+func healthyBackends(backends []*Backend) (healthy []*Backend) {
+	now := time.Now()
+	for _, b := range backends {
+		if b.healthy(now) {
+			healthy = append(healthy, b)
+		}
+	}
+	return
+}
@@ -0,0 +1,97 @@
+package triemux
+
+import (
+	"testing"
+	"time"
+)
+
+// TestPickerWeightDistribution checks that pickFrom's smooth weighted
+// round-robin distributes picks across backends in proportion to their
+// weight over a full cycle, rather than e.g. always picking the
+// highest-weighted backend.
+func TestPickerWeightDistribution(t *testing.T) {
+	a := NewBackend(nil, 3)
+	b := NewBackend(nil, 1)
+
+	p := newPicker([]*Backend{a, b})
+
+	counts := map[*Backend]int{}
+	const rounds = 4 // a.Weight + b.Weight
+	for i := 0; i < rounds; i++ {
+		backend := p.pick()
+		if backend == nil {
+			t.Fatalf("pick() returned nil on round %d", i)
+		}
+		counts[backend]++
+	}
+
+	if counts[a] != 3 {
+		t.Errorf("backend a picked %d times over %d rounds, want 3", counts[a], rounds)
+	}
+	if counts[b] != 1 {
+		t.Errorf("backend b picked %d times over %d rounds, want 1", counts[b], rounds)
+	}
+}
+
+// TestPickerSkipsUnhealthyBackend checks that a backend taken unhealthy by
+// FailureThreshold consecutive MarkFailure calls is excluded from picks
+// while a healthy alternative exists.
+func TestPickerSkipsUnhealthyBackend(t *testing.T) {
+	healthy := NewBackend(nil, 1)
+	unhealthy := NewBackend(nil, 1)
+	unhealthy.FailureThreshold = 1
+	unhealthy.Cooldown = time.Hour
+	unhealthy.MarkFailure()
+
+	p := newPicker([]*Backend{healthy, unhealthy})
+
+	for i := 0; i < 5; i++ {
+		if got := p.pick(); got != healthy {
+			t.Fatalf("pick() = %v, want the healthy backend", got)
+		}
+	}
+}
+
+// TestPickerFallsBackToUnhealthyWhenAllUnhealthy checks that, if every
+// backend is unhealthy, pick still returns one (rather than nil) so that a
+// request gets a chance of hitting a backend that's actually recovered.
+func TestPickerFallsBackToUnhealthyWhenAllUnhealthy(t *testing.T) {
+	a := NewBackend(nil, 1)
+	a.FailureThreshold = 1
+	a.Cooldown = time.Hour
+	a.MarkFailure()
+
+	b := NewBackend(nil, 1)
+	b.FailureThreshold = 1
+	b.Cooldown = time.Hour
+	b.MarkFailure()
+
+	p := newPicker([]*Backend{a, b})
+
+	if got := p.pick(); got != a && got != b {
+		t.Errorf("pick() = %v, want one of the (unhealthy) backends", got)
+	}
+}
+
+// TestBackendRecoversAfterCooldown checks that MarkSuccess (and, separately,
+// the passage of Cooldown) makes a previously unhealthy backend eligible
+// for selection again.
+func TestBackendRecoversAfterCooldown(t *testing.T) {
+	b := NewBackend(nil, 1)
+	b.FailureThreshold = 1
+	b.MarkFailure()
+
+	if b.healthy(time.Now()) {
+		t.Fatal("backend reported healthy immediately after crossing FailureThreshold")
+	}
+
+	if !b.healthy(time.Now().Add(b.Cooldown)) {
+		t.Error("backend still reported unhealthy once Cooldown had passed")
+	}
+
+	b.MarkSuccess()
+
+	if !b.healthy(time.Now()) {
+		t.Error("backend still reported unhealthy after MarkSuccess")
+	}
+}
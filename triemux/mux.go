@@ -0,0 +1,251 @@
+// Package triemux implements a multiplexer which can dispatch requests to
+// registered handlers based on the request's host and path. Routes are
+// scoped to a host (exactly, or via a wildcard pattern) or to any host, and
+// within a host are matched by longest path prefix.
+package triemux
+
+import (
+	"github.com/nickstenning/trie"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type Mux struct {
+	mu    sync.RWMutex
+	tries map[string]*trie.Trie
+	any   *trie.Trie
+}
+
+type muxEntry struct {
+	prefix bool
+	picker *picker
+}
+
+// NewMux makes a new empty Mux.
+func NewMux() *Mux {
+	return &Mux{
+		tries: make(map[string]*trie.Trie),
+		any:   trie.NewTrie(),
+	}
+}
+
+// ServeHTTP dispatches the request to a backend with a registered route
+// matching the request's host and path, or 404s.
+func (mux *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	backend, ok := mux.Lookup(r.Host, r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	backend.Handler.ServeHTTP(w, r)
+}
+
+// Handle registers the specified route (either an exact or a prefix route)
+// scoped to the given host, and associates it with the specified handler.
+// An empty host registers the route against any host that doesn't have a
+// more specific match. The host may be an exact hostname, or a wildcard
+// pattern of the form "*.example.com" or "example.*".
+func (mux *Mux) Handle(host, path string, prefix bool, handler http.Handler) {
+	mux.HandleBackends(host, path, prefix, []*Backend{NewBackend(handler, 1)})
+}
+
+// HandleBackends is like Handle, but registers a weighted pool of backends
+// for the route rather than a single handler. Each request matching the
+// route is served by one backend from the pool, chosen by smooth weighted
+// round-robin (skipping any backend currently marked unhealthy).
+func (mux *Mux) HandleBackends(host, path string, prefix bool, backends []*Backend) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	mux.trieForHost(host).Set(splitpath(path), muxEntry{
+		prefix: prefix,
+		picker: newPicker(backends),
+	})
+}
+
+// Unregister removes the route previously registered for the given host and
+// path, if any. It's implemented as a soft delete (setting the trie entry to
+// nil) rather than a true removal from the trie, since findlongestmatch
+// already has to fall through entries it can't use.
+func (mux *Mux) Unregister(host, path string) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	t, ok := mux.tries[host]
+	if host == "" {
+		t, ok = mux.any, true
+	}
+	if !ok {
+		return
+	}
+
+	t.Set(splitpath(path), nil)
+}
+
+// Lookup takes a host and a path and looks up their registered entry in the
+// mux, returning a backend picked from the matching route's pool, if any.
+// The host is matched first, trying an exact match and then progressively
+// more general wildcard patterns before falling back to routes registered
+// against any host. The candidate tries are tried in that order, and the
+// first one in which the path has a longest-prefix match (with a backend to
+// pick) wins; a host trie with no matching path falls through to the next.
+func (mux *Mux) Lookup(host, path string) (backend *Backend, ok bool) {
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+
+	for _, t := range mux.triesForHost(stripPort(host)) {
+		entry, ok := findlongestmatch(t, path)
+		if ok {
+			if backend := entry.picker.pick(); backend != nil {
+				return backend, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// trieForHost returns the trie registered for the given host, creating it if
+// necessary. An empty host returns the any-host fallback trie.
+func (mux *Mux) trieForHost(host string) *trie.Trie {
+	if host == "" {
+		return mux.any
+	}
+
+	t, ok := mux.tries[host]
+	if !ok {
+		t = trie.NewTrie()
+		mux.tries[host] = t
+	}
+	return t
+}
+
+// hostCandidate pairs a host trie with how specific its match was, so that
+// candidates drawn from both wildcard families can be ranked against each
+// other rather than just within their own family.
+type hostCandidate struct {
+	trie        *trie.Trie
+	specificity int
+}
+
+// triesForHost returns the sequence of tries that should be consulted for
+// the given host, in order from most to least specific, always ending with
+// the any-host fallback trie. Specificity is the number of labels of host
+// that a candidate's registration actually matched, so an exact match
+// (which matches every label) always outranks any wildcard, and wildcards
+// from the "*.example.com" and "example.*" families are ranked against each
+// other by that same count rather than one family being tried to
+// exhaustion before the other.
+func (mux *Mux) triesForHost(host string) (tries []*trie.Trie) {
+	labels := strings.Split(host, ".")
+	var candidates []hostCandidate
+
+	if t, ok := mux.tries[host]; ok {
+		candidates = append(candidates, hostCandidate{t, len(labels)})
+	}
+
+	// *.example.com, *.com, ... -- strip labels from the left.
+	for i := 1; i < len(labels); i++ {
+		if t, ok := mux.tries["*."+strings.Join(labels[i:], ".")]; ok {
+			candidates = append(candidates, hostCandidate{t, len(labels) - i})
+		}
+	}
+
+	// example.*, example.com.* -- strip labels from the right.
+	for i := len(labels) - 1; i > 0; i-- {
+		if t, ok := mux.tries[strings.Join(labels[:i], ".")+".*"]; ok {
+			candidates = append(candidates, hostCandidate{t, i})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].specificity > candidates[j].specificity
+	})
+
+	for _, c := range candidates {
+		tries = append(tries, c.trie)
+	}
+
+	return append(tries, mux.any)
+}
+
+// stripPort removes any trailing ":port" from a host header value.
+func stripPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// splitpath turns a slash-delimited string into a lookup path (a slice
+// containing the strings between slashes). Any leading slashes are stripped
+// before the string is split.
+func splitpath(path string) []string {
+	for strings.HasPrefix(path, "/") {
+		path = path[1:]
+	}
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
+
+// findlongestmatch will search the passed trie for the longest route matching
+// the passed path, taking into account whether or not each muxEntry is a prefix
+// route.
+//
+// The function first attempts an exact match, and if it fails to find one will
+// then chop slash-delimited sections off the end of the path in an attempt to
+// find a matching exact or prefix route.
+func findlongestmatch(t *trie.Trie, path string) (entry muxEntry, ok bool) {
+	origpath := splitpath(path)
+	copypath := origpath
+
+	// This search algorithm is potentially abusable -- it will take a
+	// (relatively) long time to establish that a path with an enormous number of
+	// slashes in doesn't have a corresponding route. The obvious fix is for the
+	// trie to keep track of how long its longest root-to-leaf path is and
+	// shortcut the lookup by chopping the appropriate number of elements off the
+	// end of the lookup.
+	//
+	// Worrying about the above is probably premature optimization, so I leave the
+	// mitigation described as an exercise for the reader.
+	for len(copypath) >= 0 {
+		val, ok := t.Get(copypath)
+		if !ok || val == nil {
+			if len(copypath) > 0 {
+				copypath = copypath[:len(copypath)-1]
+				continue
+			}
+			break
+		}
+
+		ent, ok := val.(muxEntry)
+		if !ok {
+			log.Printf("findlongestmatch: got value (%v) from trie that wasn't a muxEntry!", val)
+			break
+		}
+
+		if len(copypath) == len(origpath) {
+			return ent, true
+		}
+
+		if ent.prefix {
+			return ent, true
+		}
+
+		if len(copypath) > 0 {
+			copypath = copypath[:len(copypath)-1]
+			continue
+		}
+
+		// Fell through without finding anything or explicitly calling continue, so:
+		break
+	}
+	return muxEntry{}, false
+}
@@ -0,0 +1,156 @@
+package triemux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func namedHandler(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Backend", name)
+	})
+}
+
+func lookupName(t *testing.T, mux *Mux, host, path string) (string, bool) {
+	t.Helper()
+
+	backend, ok := mux.Lookup(host, path)
+	if !ok {
+		return "", false
+	}
+
+	rec := httptest.NewRecorder()
+	backend.Handler.ServeHTTP(rec, httptest.NewRequest("GET", "http://"+host+path, nil))
+	return rec.Header().Get("X-Backend"), true
+}
+
+// TestTriesForHostSpecificity covers the ordering triesForHost is
+// responsible for: an exact host match beats any wildcard, and wildcards
+// from the "*.example.com" and "example.*" families are ranked against each
+// other by how many labels of the host they actually matched, rather than
+// one family being tried to exhaustion before the other.
+func TestTriesForHostSpecificity(t *testing.T) {
+	cases := []struct {
+		name        string
+		registered  map[string]string // host pattern -> backend name
+		lookupHost  string
+		wantBackend string
+	}{
+		{
+			name: "exact beats left and right wildcard",
+			registered: map[string]string{
+				"a.b.example.com": "exact",
+				"*.b.example.com": "left",
+				"a.b.example.*":   "right",
+			},
+			lookupHost:  "a.b.example.com",
+			wantBackend: "exact",
+		},
+		{
+			name: "more specific left wildcard beats less specific left wildcard",
+			registered: map[string]string{
+				"*.b.example.com": "specific",
+				"*.example.com":   "general",
+			},
+			lookupHost:  "a.b.example.com",
+			wantBackend: "specific",
+		},
+		{
+			name: "more specific right wildcard beats less specific right wildcard",
+			registered: map[string]string{
+				"a.b.example.*": "specific",
+				"a.*":           "general",
+			},
+			lookupHost:  "a.b.example.com",
+			wantBackend: "specific",
+		},
+		{
+			name: "left and right wildcards ranked against each other by specificity",
+			registered: map[string]string{
+				"*.b.example.com": "left-specific",
+				"a.*":             "right-general",
+			},
+			lookupHost:  "a.b.example.com",
+			wantBackend: "left-specific",
+		},
+		{
+			name: "less specific left wildcard loses to more specific right wildcard",
+			registered: map[string]string{
+				"*.com":         "left-general",
+				"a.b.example.*": "right-specific",
+			},
+			lookupHost:  "a.b.example.com",
+			wantBackend: "right-specific",
+		},
+		{
+			name: "any-host fallback used when nothing else matches",
+			registered: map[string]string{
+				"other.example.com": "other",
+			},
+			lookupHost:  "a.b.example.com",
+			wantBackend: "",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mux := NewMux()
+			for host, name := range c.registered {
+				mux.Handle(host, "/", false, namedHandler(name))
+			}
+			if c.wantBackend == "" {
+				mux.Handle("", "/", false, namedHandler("any"))
+			}
+
+			got, ok := lookupName(t, mux, c.lookupHost, "/")
+			if !ok {
+				t.Fatalf("Lookup(%q, \"/\"): no match", c.lookupHost)
+			}
+
+			want := c.wantBackend
+			if want == "" {
+				want = "any"
+			}
+			if got != want {
+				t.Errorf("Lookup(%q, \"/\") = backend %q, want %q", c.lookupHost, got, want)
+			}
+		})
+	}
+}
+
+// TestLookupPortIsStripped checks that a host header carrying a port is
+// matched against routes registered without one.
+func TestLookupPortIsStripped(t *testing.T) {
+	mux := NewMux()
+	mux.Handle("example.com", "/", false, namedHandler("backend"))
+
+	got, ok := lookupName(t, mux, "example.com:8080", "/")
+	if !ok || got != "backend" {
+		t.Errorf("Lookup(%q, \"/\") = (%q, %v), want (%q, true)", "example.com:8080", got, ok, "backend")
+	}
+}
+
+// TestLookupNoMatch checks that a host with nothing registered for it, and
+// no any-host fallback, reports no match.
+func TestLookupNoMatch(t *testing.T) {
+	mux := NewMux()
+	mux.Handle("example.com", "/", false, namedHandler("backend"))
+
+	if _, ok := mux.Lookup("other.com", "/"); ok {
+		t.Error("Lookup on an unregistered host with no fallback matched, want no match")
+	}
+}
+
+// TestUnregister checks that Unregister removes a route so subsequent
+// lookups for it fall through to whatever's next (here, no match at all).
+func TestUnregister(t *testing.T) {
+	mux := NewMux()
+	mux.Handle("example.com", "/", false, namedHandler("backend"))
+
+	mux.Unregister("example.com", "/")
+
+	if _, ok := mux.Lookup("example.com", "/"); ok {
+		t.Error("Lookup matched after Unregister, want no match")
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultUpgradeDialTimeout = 10 * time.Second
+	defaultUpgradeIdleTimeout = 60 * time.Second
+)
+
+// upgradeProxy wraps a reverse proxy handler, adding support for requests
+// which ask to be upgraded (WebSockets, and anything else that uses the
+// Connection: Upgrade mechanism). httputil.ReverseProxy doesn't support
+// these: it buffers and closes the connection rather than handing it over,
+// so upgrade requests are instead served by hijacking the client connection,
+// dialling the backend directly, replaying the request onto it, and piping
+// bytes between the two connections until either side closes. Requests that
+// aren't upgrades are served by the wrapped proxy as before.
+type upgradeProxy struct {
+	target      *url.URL
+	proxy       http.Handler
+	transform   Transform
+	dialTimeout time.Duration
+	idleTimeout time.Duration
+
+	// onFailure and onSuccess report the outcome of dialling and writing to
+	// the backend, so that an upgrade request affects the backend's passive
+	// health check the same way an ordinary request does via
+	// wireHealthCheck's proxy.ErrorHandler/ModifyResponse -- which upgrade
+	// requests, bypassing httputil.ReverseProxy, never trigger.
+	onFailure func()
+	onSuccess func()
+}
+
+// newUpgradeProxy returns a handler which serves upgrade requests itself and
+// delegates everything else to proxy. transform is the application's
+// configured request transform, applied to upgrade requests the same way
+// the reverse proxy's Director applies it to ordinary ones.
+func newUpgradeProxy(target *url.URL, proxy http.Handler, transform Transform, dialTimeout, idleTimeout time.Duration, onFailure, onSuccess func()) *upgradeProxy {
+	return &upgradeProxy{
+		target:      target,
+		proxy:       proxy,
+		transform:   transform,
+		dialTimeout: dialTimeout,
+		idleTimeout: idleTimeout,
+		onFailure:   onFailure,
+		onSuccess:   onSuccess,
+	}
+}
+
+func (p *upgradeProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if isUpgrade(r) {
+		p.serveUpgrade(w, r)
+		return
+	}
+
+	if isEventStream(r) {
+		w = &flushWriter{ResponseWriter: w}
+	}
+
+	p.proxy.ServeHTTP(w, r)
+}
+
+// serveUpgrade hijacks the client connection, dials the backend, replays the
+// request onto it, and then pipes bytes between the two connections until
+// either side closes.
+func (p *upgradeProxy) serveUpgrade(w http.ResponseWriter, r *http.Request) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "upgrade not supported", http.StatusInternalServerError)
+		return
+	}
+
+	clientConn, rw, err := hijacker.Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer clientConn.Close()
+
+	// Hijack can return a conn whose reader already has bytes buffered from
+	// the underlying socket -- e.g. a client that pipelines its first
+	// WebSocket frame in the same write as the upgrade request. Reading from
+	// clientConn directly after this point would silently drop those bytes,
+	// so route all further reads through rw.Reader instead.
+	client := &bufferedConn{Conn: clientConn, r: rw.Reader}
+
+	backendConn, err := dialBackend(p.target, p.dialTimeout)
+	if err != nil {
+		log.Printf("router: upgrade: couldn't dial backend %s: %v", p.target.Host, err)
+		p.onFailure()
+		return
+	}
+	defer backendConn.Close()
+
+	if err := writeUpgradeRequest(backendConn, r, p.target, p.transform); err != nil {
+		log.Printf("router: upgrade: couldn't write request to backend %s: %v", p.target.Host, err)
+		p.onFailure()
+		return
+	}
+
+	// There's no response to inspect for a hijacked connection, so a
+	// successful dial and write is as much of a health signal as we get.
+	p.onSuccess()
+
+	pipe(client, backendConn, p.idleTimeout)
+}
+
+// bufferedConn is a net.Conn that reads through r instead of going straight
+// to the underlying connection, so that bytes already buffered by Hijack
+// aren't lost. Everything other than Read (including Write and the deadline
+// methods pipe relies on) is the embedded net.Conn's own behaviour.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// dialBackend dials target, respecting its scheme: a plain TCP connection
+// for "http", or a TLS connection (with SNI set from the backend's
+// hostname) for "https". Upgrade requests bypass httputil.ReverseProxy
+// entirely, so without this an "https" backend would silently be spoken to
+// in cleartext.
+func dialBackend(target *url.URL, dialTimeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+
+	if target.Scheme == "https" {
+		return tls.DialWithDialer(dialer, "tcp", target.Host, &tls.Config{
+			ServerName: hostOnly(target.Host),
+		})
+	}
+
+	return dialer.Dial("tcp", target.Host)
+}
+
+// hostOnly strips any ":port" suffix from a host:port string.
+func hostOnly(host string) string {
+	h, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return host
+	}
+	return h
+}
+
+// writeUpgradeRequest replays r's request line and headers onto conn,
+// applying t the same way applyTransform does for ordinary requests (Host
+// is only rewritten to target when t.RewriteHost is set, matching the
+// ordinary-request path instead of always forcing it), and appending the
+// same X-Forwarded-* headers an ordinary request would get.
+func writeUpgradeRequest(conn net.Conn, r *http.Request, target *url.URL, t Transform) error {
+	host, proto := r.Host, requestScheme(r)
+	path := transformPath(r.URL.Path, t)
+
+	requestURI := path
+	if r.URL.RawQuery != "" {
+		requestURI += "?" + r.URL.RawQuery
+	}
+
+	if _, err := fmt.Fprintf(conn, "%s %s HTTP/1.1\r\n", r.Method, requestURI); err != nil {
+		return err
+	}
+
+	headers := make(http.Header, len(r.Header))
+	for k, v := range r.Header {
+		headers[k] = v
+	}
+	applyHeaderTransform(headers, t)
+	appendForwardedHeaders(headers, host, proto)
+	appendForwardedFor(headers, r.RemoteAddr)
+
+	hostHeader := host
+	if t.RewriteHost {
+		hostHeader = target.Host
+	}
+	headers.Set("Host", hostHeader)
+
+	if err := headers.Write(conn); err != nil {
+		return err
+	}
+
+	_, err := conn.Write([]byte("\r\n"))
+	return err
+}
+
+// pipe copies bytes bidirectionally between a and b until either side closes
+// or goes idle for longer than idleTimeout. It returns as soon as one
+// direction has stopped; the other keeps running until its own read or write
+// fails (e.g. once the caller closes both connections).
+func pipe(a, b net.Conn, idleTimeout time.Duration) {
+	done := make(chan struct{}, 2)
+
+	relay := func(dst, src net.Conn) {
+		defer func() { done <- struct{}{} }()
+
+		buf := make([]byte, 32*1024)
+		for {
+			if idleTimeout > 0 {
+				src.SetReadDeadline(time.Now().Add(idleTimeout))
+			}
+			n, err := src.Read(buf)
+			if n > 0 {
+				if _, werr := dst.Write(buf[:n]); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	go relay(a, b)
+	go relay(b, a)
+	<-done
+}
+
+func isUpgrade(r *http.Request) bool {
+	return strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
+}
+
+func isEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// flushWriter wraps an http.ResponseWriter, flushing after every write so
+// streamed responses (e.g. text/event-stream) reach the client as they're
+// written rather than sitting in a buffer.
+type flushWriter struct {
+	http.ResponseWriter
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.ResponseWriter.Write(p)
+	if flusher, ok := f.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestWriteUpgradeRequestAppliesTransform is the raw-upgrade-fixture
+// equivalent of TestBackendHandlerAppliesTransform: it checks that the
+// request line and headers written onto the backend connection for an
+// upgrade request have the same transform and X-Forwarded-* treatment as an
+// ordinary request gets from the Director in newBackendHandler.
+func TestWriteUpgradeRequestAppliesTransform(t *testing.T) {
+	origReq := httptest.NewRequest("GET", "http://example.com/api/chat?x=1", nil)
+	origReq.Header.Set("Connection", "Upgrade")
+	origReq.Header.Set("Upgrade", "websocket")
+	origReq.RemoteAddr = "10.0.0.5:5555"
+
+	target, err := url.Parse("http://backend.internal:9000")
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	transform := Transform{
+		RewriteHost:     true,
+		StripPathPrefix: "/api",
+		SetHeaders:      map[string]string{"X-Added": "1"},
+	}
+
+	serverConn, clientConn := net.Pipe()
+
+	type result struct {
+		req *http.Request
+		err error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		r, err := http.ReadRequest(bufio.NewReader(serverConn))
+		resultCh <- result{r, err}
+	}()
+
+	if err := writeUpgradeRequest(clientConn, origReq, target, transform); err != nil {
+		t.Fatalf("writeUpgradeRequest: %v", err)
+	}
+	clientConn.Close()
+
+	res := <-resultCh
+	if res.err != nil {
+		t.Fatalf("ReadRequest: %v", res.err)
+	}
+
+	if got, want := res.req.URL.Path, "/chat"; got != want {
+		t.Errorf("path = %q, want %q (StripPathPrefix: /api)", got, want)
+	}
+	if got, want := res.req.URL.RawQuery, "x=1"; got != want {
+		t.Errorf("query = %q, want %q", got, want)
+	}
+	if got, want := res.req.Host, "backend.internal:9000"; got != want {
+		t.Errorf("Host = %q, want %q (RewriteHost: true)", got, want)
+	}
+	if got, want := res.req.Header.Get("X-Added"), "1"; got != want {
+		t.Errorf("X-Added = %q, want %q (SetHeaders)", got, want)
+	}
+	if got, want := res.req.Header.Get("X-Forwarded-Host"), "example.com"; got != want {
+		t.Errorf("X-Forwarded-Host = %q, want %q", got, want)
+	}
+	if got, want := res.req.Header.Get("X-Forwarded-For"), "10.0.0.5"; got != want {
+		t.Errorf("X-Forwarded-For = %q, want %q", got, want)
+	}
+}
+
+// TestWriteUpgradeRequestLeavesHostAloneWithoutRewriteHost checks that, with
+// RewriteHost unset, the upgrade path sends the original incoming Host
+// rather than unconditionally forcing the backend's -- matching the
+// ordinary-request path, where the Director only rewrites Host if the
+// transform asks for it.
+func TestWriteUpgradeRequestLeavesHostAloneWithoutRewriteHost(t *testing.T) {
+	origReq := httptest.NewRequest("GET", "http://example.com/chat", nil)
+	origReq.RemoteAddr = "10.0.0.5:5555"
+
+	target, _ := url.Parse("http://backend.internal:9000")
+
+	serverConn, clientConn := net.Pipe()
+	resultCh := make(chan *http.Request, 1)
+	go func() {
+		r, _ := http.ReadRequest(bufio.NewReader(serverConn))
+		resultCh <- r
+	}()
+
+	if err := writeUpgradeRequest(clientConn, origReq, target, Transform{}); err != nil {
+		t.Fatalf("writeUpgradeRequest: %v", err)
+	}
+	clientConn.Close()
+
+	req := <-resultCh
+	if got, want := req.Host, "example.com"; got != want {
+		t.Errorf("Host = %q, want %q (RewriteHost: false)", got, want)
+	}
+}
+
+// TestUpgradeProxyPassesThroughNonUpgradeRequests checks that ordinary
+// requests still reach the wrapped proxy unchanged.
+func TestUpgradeProxyPassesThroughNonUpgradeRequests(t *testing.T) {
+	var served bool
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = true
+	})
+
+	target, _ := url.Parse("http://backend.invalid")
+	p := newUpgradeProxy(target, inner, Transform{}, time.Second, time.Second, func() {}, func() {})
+
+	server := httptest.NewServer(p)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	resp.Body.Close()
+
+	if !served {
+		t.Error("non-upgrade request was not passed through to the wrapped proxy")
+	}
+}
+
+// TestBufferedConnReadsBufferedBytesFirst guards against the bytes-dropped
+// regression a raw net.Conn read after Hijack would reintroduce: a client
+// that pipelines payload bytes in the same write as the upgrade request
+// leaves those bytes sitting in Hijack's buffered reader, not on the
+// underlying conn, so bufferedConn must read through that buffer rather
+// than skipping straight to the conn.
+func TestBufferedConnReadsBufferedBytesFirst(t *testing.T) {
+	serverSide, clientSide := net.Pipe()
+	defer serverSide.Close()
+	defer clientSide.Close()
+
+	const payload = "buffered-then-live"
+	go clientSide.Write([]byte(payload))
+
+	br := bufio.NewReader(serverSide)
+	if _, err := br.Peek(1); err != nil {
+		t.Fatalf("Peek: %v", err)
+	}
+
+	bc := &bufferedConn{Conn: serverSide, r: br}
+
+	buf := make([]byte, len(payload))
+	if _, err := io.ReadFull(bc, buf); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	if got := string(buf); got != payload {
+		t.Errorf("Read = %q, want %q", got, payload)
+	}
+}
+
+func TestIsUpgrade(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	if isUpgrade(r) {
+		t.Error("plain request reported as an upgrade")
+	}
+
+	r.Header.Set("Connection", "Upgrade")
+	if !isUpgrade(r) {
+		t.Error("request with Connection: Upgrade not reported as an upgrade")
+	}
+}